@@ -0,0 +1,39 @@
+// Package backend abstracts where the gateway's users, public keys and
+// source-to-organization routing come from. Historically all three were
+// loaded once from the gateway's own config file into process-local maps,
+// which meant rotating a user or a key required a restart. The Backend
+// interface lets that state live in HolmesStorage instead, shared across
+// gateway instances, while keeping the config-file behavior as a fallback.
+package backend
+
+import (
+	"crypto/rsa"
+	"../utils"
+)
+
+// AuditEntry is a single line appended to the backend's audit log, e.g. for
+// key rotation or user changes.
+type AuditEntry struct {
+	Action string
+	Actor  string
+	Detail string
+}
+
+// Backend is implemented by configBackend (reads the gateway's own config
+// file) and storageBackend (talks to HolmesStorage over HTTP). Callers
+// should treat lookups as cheap; implementations are expected to cache.
+type Backend interface {
+	GetUser(name string) (*tasking.User, error)
+	ListOrganizations() ([]tasking.Organization, error)
+	GetSourceRoute(source string) (*tasking.Organization, error)
+	GetPublicKey(kid string) (*rsa.PublicKey, error)
+	PutPublicKey(kid string, key *rsa.PublicKey) error
+	RevokePublicKey(kid string) error
+	AppendAudit(entry AuditEntry) error
+
+	// Invalidations emits a kind ("users", "keys" or "sources") whenever the
+	// backend's cache for that kind was refreshed, so callers that keep
+	// their own derived state (e.g. the gateway's own organization pointer)
+	// know when to re-derive it.
+	Invalidations() <-chan string
+}
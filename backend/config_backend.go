@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"crypto/rsa"
+	"errors"
+	"log"
+	"sync"
+
+	"../utils"
+)
+
+// ConfigBackendOptions is the subset of the gateway's config file needed to
+// build a configBackend.
+type ConfigBackendOptions struct {
+	AllowedUsers    []tasking.User
+	Organizations   []tasking.Organization
+	SourcesKeysPath string
+}
+
+// configBackend serves users, organizations and public keys straight out of
+// the gateway's own config file, i.e. today's behavior. Public keys are
+// additionally watched on disk, same as the old readKeys().
+type configBackend struct {
+	usersMutex sync.RWMutex
+	users      map[string]*tasking.User
+
+	orgsMutex     sync.RWMutex
+	organizations []tasking.Organization
+	srcRouter     map[string]*tasking.Organization
+
+	keysMutex sync.Mutex
+	keys      map[string]*rsa.PublicKey
+
+	invalidations chan string
+}
+
+// NewConfigBackend builds a Backend that mirrors the gateway's legacy,
+// config-file-driven behavior. Public keys found under opts.SourcesKeysPath
+// are watched for changes and hot-reloaded, same as before.
+func NewConfigBackend(opts ConfigBackendOptions) Backend {
+	b := &configBackend{
+		users:         make(map[string]*tasking.User),
+		srcRouter:     make(map[string]*tasking.Organization),
+		keys:          make(map[string]*rsa.PublicKey),
+		invalidations: make(chan string, 16),
+	}
+
+	for u := range opts.AllowedUsers {
+		user := &(opts.AllowedUsers[u])
+		b.users[user.Name] = user
+	}
+
+	b.organizations = opts.Organizations
+	for num, org := range b.organizations {
+		for _, src := range org.Sources {
+			b.srcRouter[src] = &b.organizations[num]
+		}
+	}
+
+	tasking.LoadKeysAndWatch(opts.SourcesKeysPath, ".pub",
+		func(name string) {
+			b.keysMutex.Lock()
+			delete(b.keys, name)
+			b.keysMutex.Unlock()
+			b.notify("keys")
+		},
+		func(path string) {
+			key, name, err := tasking.LoadPublicKey(path)
+			if err != nil {
+				log.Printf("Error reading key (%s):%s\n", path, err)
+				return
+			}
+			b.keysMutex.Lock()
+			b.keys[name] = key
+			b.keysMutex.Unlock()
+			b.notify("keys")
+		})
+
+	return b
+}
+
+func (b *configBackend) notify(kind string) {
+	select {
+	case b.invalidations <- kind:
+	default:
+		// Slow/no consumer; invalidation channel is a best-effort signal,
+		// the cache itself is already up to date.
+	}
+}
+
+func (b *configBackend) GetUser(name string) (*tasking.User, error) {
+	b.usersMutex.RLock()
+	defer b.usersMutex.RUnlock()
+	user, exists := b.users[name]
+	if !exists {
+		return nil, errors.New("User not found")
+	}
+	return user, nil
+}
+
+func (b *configBackend) ListOrganizations() ([]tasking.Organization, error) {
+	b.orgsMutex.RLock()
+	defer b.orgsMutex.RUnlock()
+	return b.organizations, nil
+}
+
+func (b *configBackend) GetSourceRoute(source string) (*tasking.Organization, error) {
+	b.orgsMutex.RLock()
+	defer b.orgsMutex.RUnlock()
+	org, found := b.srcRouter[source]
+	if !found {
+		return nil, errors.New("No route for source")
+	}
+	return org, nil
+}
+
+func (b *configBackend) GetPublicKey(kid string) (*rsa.PublicKey, error) {
+	b.keysMutex.Lock()
+	defer b.keysMutex.Unlock()
+	key, exists := b.keys[kid]
+	if !exists {
+		return nil, errors.New("Public Key not found")
+	}
+	return key, nil
+}
+
+func (b *configBackend) PutPublicKey(kid string, key *rsa.PublicKey) error {
+	b.keysMutex.Lock()
+	b.keys[kid] = key
+	b.keysMutex.Unlock()
+	b.notify("keys")
+	return nil
+}
+
+func (b *configBackend) RevokePublicKey(kid string) error {
+	b.keysMutex.Lock()
+	delete(b.keys, kid)
+	b.keysMutex.Unlock()
+	b.notify("keys")
+	return nil
+}
+
+func (b *configBackend) AppendAudit(entry AuditEntry) error {
+	log.Printf("audit: %+v\n", entry)
+	return nil
+}
+
+func (b *configBackend) Invalidations() <-chan string {
+	return b.invalidations
+}
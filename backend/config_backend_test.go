@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"../utils"
+)
+
+func TestConfigBackendGetUser(t *testing.T) {
+	b := NewConfigBackend(ConfigBackendOptions{
+		AllowedUsers: []tasking.User{{Id: 1, Name: "alice"}},
+	})
+
+	user, err := b.GetUser("alice")
+	if err != nil {
+		t.Fatalf("GetUser(alice): %v", err)
+	}
+	if user.Id != 1 {
+		t.Errorf("GetUser(alice).Id = %d, want 1", user.Id)
+	}
+
+	if _, err := b.GetUser("no-such-user"); err == nil {
+		t.Error("GetUser succeeded for a user that was never configured")
+	}
+}
+
+func TestConfigBackendSourceRouting(t *testing.T) {
+	org := tasking.Organization{Name: "org-a", Sources: []string{"source-a", "source-b"}}
+	b := NewConfigBackend(ConfigBackendOptions{
+		Organizations: []tasking.Organization{org},
+	})
+
+	route, err := b.GetSourceRoute("source-b")
+	if err != nil {
+		t.Fatalf("GetSourceRoute(source-b): %v", err)
+	}
+	if route.Name != "org-a" {
+		t.Errorf("GetSourceRoute(source-b).Name = %q, want org-a", route.Name)
+	}
+
+	if _, err := b.GetSourceRoute("no-such-source"); err == nil {
+		t.Error("GetSourceRoute succeeded for an unrouted source")
+	}
+}
+
+func TestConfigBackendPutAndRevokePublicKey(t *testing.T) {
+	b := NewConfigBackend(ConfigBackendOptions{})
+
+	if _, err := b.GetPublicKey("kid-1"); err == nil {
+		t.Error("GetPublicKey succeeded before the key was ever stored")
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	key := &priv.PublicKey
+	if err := b.PutPublicKey("kid-1", key); err != nil {
+		t.Fatalf("PutPublicKey: %v", err)
+	}
+	got, err := b.GetPublicKey("kid-1")
+	if err != nil {
+		t.Fatalf("GetPublicKey after PutPublicKey: %v", err)
+	}
+	if got != key {
+		t.Error("GetPublicKey did not return the key that was just stored")
+	}
+
+	if err := b.RevokePublicKey("kid-1"); err != nil {
+		t.Fatalf("RevokePublicKey: %v", err)
+	}
+	if _, err := b.GetPublicKey("kid-1"); err == nil {
+		t.Error("GetPublicKey succeeded after the key was revoked")
+	}
+}
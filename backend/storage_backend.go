@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"../utils"
+)
+
+// StorageBackendOptions configures a storageBackend.
+type StorageBackendOptions struct {
+	BaseURI         string        // HolmesStorage base URI, e.g. https://storage.example.org
+	Client          *http.Client  // HTTP client to use; a plain &http.Client{} if nil
+	RefreshInterval time.Duration // How often the organization/source cache is refreshed in the background
+}
+
+// storageBackend reads users, organizations, source routing and public keys
+// from HolmesStorage over HTTP, caching results so the hot request path
+// doesn't do a network round-trip per lookup. A background goroutine
+// refreshes the organization/source cache periodically, and also expires
+// the user and public-key caches on the same interval, so changes made in
+// HolmesStorage (including password/scope changes and key rotation or
+// revocation) propagate to all gateway instances without a restart.
+type storageBackend struct {
+	baseURI string
+	client  *http.Client
+
+	usersMutex sync.RWMutex
+	users      map[string]*tasking.User
+
+	orgsMutex     sync.RWMutex
+	organizations []tasking.Organization
+	srcRouter     map[string]*tasking.Organization
+
+	keysMutex sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+
+	invalidations chan string
+}
+
+// NewStorageBackend builds a Backend backed by HolmesStorage.
+func NewStorageBackend(opts StorageBackendOptions) Backend {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	refresh := opts.RefreshInterval
+	if refresh == 0 {
+		refresh = time.Minute
+	}
+
+	b := &storageBackend{
+		baseURI:       opts.BaseURI,
+		client:        client,
+		users:         make(map[string]*tasking.User),
+		srcRouter:     make(map[string]*tasking.Organization),
+		keys:          make(map[string]*rsa.PublicKey),
+		invalidations: make(chan string, 16),
+	}
+
+	b.refreshOrganizations()
+	go b.refreshLoop(refresh)
+
+	return b
+}
+
+func (b *storageBackend) notify(kind string) {
+	select {
+	case b.invalidations <- kind:
+	default:
+	}
+}
+
+func (b *storageBackend) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		b.refreshOrganizations()
+		b.expireUsers()
+		b.expireKeys()
+	}
+}
+
+// expireUsers drops the cached users, so the next GetUser call for each of
+// them re-fetches from HolmesStorage. This bounds how long a password or
+// scope change can stay invisible to a running gateway to one refresh
+// interval, instead of until the process restarts.
+func (b *storageBackend) expireUsers() {
+	b.usersMutex.Lock()
+	b.users = make(map[string]*tasking.User)
+	b.usersMutex.Unlock()
+}
+
+// expireKeys drops the cached public keys, so a rotated or revoked key is
+// re-fetched (or fails to resolve) within one refresh interval instead of
+// staying cached for the process lifetime.
+func (b *storageBackend) expireKeys() {
+	b.keysMutex.Lock()
+	b.keys = make(map[string]*rsa.PublicKey)
+	b.keysMutex.Unlock()
+}
+
+func (b *storageBackend) refreshOrganizations() {
+	var organizations []tasking.Organization
+	if err := b.getJSON("/organizations", &organizations); err != nil {
+		log.Println("Error refreshing organizations from storage: ", err)
+		return
+	}
+
+	srcRouter := make(map[string]*tasking.Organization)
+	for num, org := range organizations {
+		for _, src := range org.Sources {
+			srcRouter[src] = &organizations[num]
+		}
+	}
+
+	b.orgsMutex.Lock()
+	b.organizations = organizations
+	b.srcRouter = srcRouter
+	b.orgsMutex.Unlock()
+
+	b.notify("sources")
+}
+
+func (b *storageBackend) getJSON(path string, out interface{}) error {
+	resp, err := b.client.Get(b.baseURI + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storage returned status %d for %s", resp.StatusCode, path)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (b *storageBackend) GetUser(name string) (*tasking.User, error) {
+	b.usersMutex.RLock()
+	user, cached := b.users[name]
+	b.usersMutex.RUnlock()
+	if cached {
+		return user, nil
+	}
+
+	var fetched tasking.User
+	if err := b.getJSON("/users/"+name, &fetched); err != nil {
+		return nil, err
+	}
+
+	b.usersMutex.Lock()
+	b.users[name] = &fetched
+	b.usersMutex.Unlock()
+	b.notify("users")
+	return &fetched, nil
+}
+
+func (b *storageBackend) ListOrganizations() ([]tasking.Organization, error) {
+	b.orgsMutex.RLock()
+	defer b.orgsMutex.RUnlock()
+	return b.organizations, nil
+}
+
+func (b *storageBackend) GetSourceRoute(source string) (*tasking.Organization, error) {
+	b.orgsMutex.RLock()
+	defer b.orgsMutex.RUnlock()
+	org, found := b.srcRouter[source]
+	if !found {
+		return nil, errors.New("No route for source")
+	}
+	return org, nil
+}
+
+func (b *storageBackend) GetPublicKey(kid string) (*rsa.PublicKey, error) {
+	b.keysMutex.RLock()
+	key, cached := b.keys[kid]
+	b.keysMutex.RUnlock()
+	if cached {
+		return key, nil
+	}
+
+	resp, err := b.client.Get(b.baseURI + "/keys/" + kid)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage returned status %d for key %s", resp.StatusCode, kid)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("Could not decode PEM public key from storage")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Key from storage is not an RSA public key")
+	}
+
+	b.keysMutex.Lock()
+	b.keys[kid] = key
+	b.keysMutex.Unlock()
+	b.notify("keys")
+	return key, nil
+}
+
+func (b *storageBackend) PutPublicKey(kid string, key *rsa.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	req, err := http.NewRequest("PUT", b.baseURI+"/keys/"+kid, bytes.NewReader(pemBytes))
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("storage returned status %d while storing key %s", resp.StatusCode, kid)
+	}
+
+	b.keysMutex.Lock()
+	b.keys[kid] = key
+	b.keysMutex.Unlock()
+	b.notify("keys")
+	return nil
+}
+
+func (b *storageBackend) RevokePublicKey(kid string) error {
+	req, err := http.NewRequest("DELETE", b.baseURI+"/keys/"+kid, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("storage returned status %d while revoking key %s", resp.StatusCode, kid)
+	}
+
+	b.keysMutex.Lock()
+	delete(b.keys, kid)
+	b.keysMutex.Unlock()
+	b.notify("keys")
+	return nil
+}
+
+func (b *storageBackend) AppendAudit(entry AuditEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Post(b.baseURI+"/audit", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("storage returned status %d while appending audit entry", resp.StatusCode)
+	}
+	return nil
+}
+
+func (b *storageBackend) Invalidations() <-chan string {
+	return b.invalidations
+}
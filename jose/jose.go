@@ -0,0 +1,69 @@
+// Package jose provides the JWS/JWE envelope used to replace the gateway's
+// hand-rolled RSA+AES ticket encryption. Tickets are signed as a compact JWS
+// (RS256) and wrapped in a compact JWE (RSA-OAEP/A256GCM) addressed to the
+// destination organization's public key, identified by its key fingerprint
+// ("kid").
+package jose
+
+import (
+	"crypto/rsa"
+	"time"
+
+	gojose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"../utils"
+)
+
+// ticketClaims is the payload of the signed ticket JWT: the standard claims
+// (currently only "exp") plus the tasks being issued.
+type ticketClaims struct {
+	jwt.Claims
+	Tasks []tasking.Task `json:"tasks"`
+}
+
+// SignTicket signs tasks as a compact JWS (RS256), using kid as the "kid"
+// header so the recipient can look up the matching verification key, and exp
+// as the expiration claim instead of the ad-hoc Expiration field the legacy
+// Ticket struct used.
+func SignTicket(tasks []tasking.Task, signKey *rsa.PrivateKey, kid string, ttl time.Duration) (string, error) {
+	signer, err := gojose.NewSigner(
+		gojose.SigningKey{Algorithm: gojose.RS256, Key: signKey},
+		(&gojose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid))
+	if err != nil {
+		return "", err
+	}
+
+	claims := ticketClaims{
+		Claims: jwt.Claims{Expiry: jwt.NewNumericDate(time.Now().Add(ttl))},
+		Tasks:  tasks,
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// EncryptTicketFor wraps a signed ticket (compact JWS) in a compact JWE,
+// encrypting the CEK with RSA-OAEP to the destination organization's public
+// key and the content with A256GCM.
+func EncryptTicketFor(signedTicket string, destKey *rsa.PublicKey, destKeyID string) (string, error) {
+	encrypter, err := gojose.NewEncrypter(
+		gojose.A256GCM,
+		gojose.Recipient{Algorithm: gojose.RSA_OAEP, Key: destKey, KeyID: destKeyID},
+		nil)
+	if err != nil {
+		return "", err
+	}
+	obj, err := encrypter.Encrypt([]byte(signedTicket))
+	if err != nil {
+		return "", err
+	}
+	return obj.CompactSerialize()
+}
+
+// DecryptReply decrypts a compact JWE reply that was encrypted to the
+// gateway's own public key, returning the plaintext body.
+func DecryptReply(jwe string, key *rsa.PrivateKey) ([]byte, error) {
+	obj, err := gojose.ParseEncrypted(jwe)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Decrypt(key)
+}
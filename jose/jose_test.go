@@ -0,0 +1,131 @@
+package jose
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	gojose "gopkg.in/square/go-jose.v2"
+	"../utils"
+)
+
+func mustGenerateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return key
+}
+
+func TestSignTicketProducesAVerifiableJWS(t *testing.T) {
+	signKey := mustGenerateKey(t)
+	tasks := []tasking.Task{{Source: "source-a", Filename: "a.bin"}}
+
+	signed, err := SignTicket(tasks, signKey, "signing-kid", time.Hour)
+	if err != nil {
+		t.Fatalf("SignTicket: %v", err)
+	}
+
+	obj, err := gojose.ParseSigned(signed)
+	if err != nil {
+		t.Fatalf("ParseSigned: %v", err)
+	}
+	if got := obj.Signatures[0].Header.KeyID; got != "signing-kid" {
+		t.Errorf("kid header = %q, want %q", got, "signing-kid")
+	}
+	payload, err := obj.Verify(&signKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(payload) == 0 {
+		t.Error("verified payload is empty")
+	}
+}
+
+func TestSignTicketRejectsTamperedSignature(t *testing.T) {
+	signKey := mustGenerateKey(t)
+	otherKey := mustGenerateKey(t)
+	tasks := []tasking.Task{{Source: "source-a"}}
+
+	signed, err := SignTicket(tasks, signKey, "signing-kid", time.Hour)
+	if err != nil {
+		t.Fatalf("SignTicket: %v", err)
+	}
+
+	obj, err := gojose.ParseSigned(signed)
+	if err != nil {
+		t.Fatalf("ParseSigned: %v", err)
+	}
+	if _, err := obj.Verify(&otherKey.PublicKey); err == nil {
+		t.Error("Verify succeeded against the wrong public key")
+	}
+}
+
+func TestEncryptTicketForAndDecryptReplyRoundTrip(t *testing.T) {
+	// The gateway's encryption keypair is expected to be distinct from its
+	// signing keypair, so exercise them as two separate keys here too.
+	signKey := mustGenerateKey(t)
+	encryptKey := mustGenerateKey(t)
+	tasks := []tasking.Task{{Source: "source-a", Filename: "a.bin"}}
+
+	signed, err := SignTicket(tasks, signKey, "signing-kid", time.Hour)
+	if err != nil {
+		t.Fatalf("SignTicket: %v", err)
+	}
+
+	encrypted, err := EncryptTicketFor(signed, &encryptKey.PublicKey, "encrypt-kid")
+	if err != nil {
+		t.Fatalf("EncryptTicketFor: %v", err)
+	}
+
+	plaintext, err := DecryptReply(encrypted, encryptKey)
+	if err != nil {
+		t.Fatalf("DecryptReply: %v", err)
+	}
+	if string(plaintext) != signed {
+		t.Errorf("DecryptReply = %q, want the original signed JWS %q", plaintext, signed)
+	}
+}
+
+func TestDecryptReplyRejectsTamperedCiphertext(t *testing.T) {
+	signKey := mustGenerateKey(t)
+	encryptKey := mustGenerateKey(t)
+	tasks := []tasking.Task{{Source: "source-a"}}
+
+	signed, err := SignTicket(tasks, signKey, "signing-kid", time.Hour)
+	if err != nil {
+		t.Fatalf("SignTicket: %v", err)
+	}
+	encrypted, err := EncryptTicketFor(signed, &encryptKey.PublicKey, "encrypt-kid")
+	if err != nil {
+		t.Fatalf("EncryptTicketFor: %v", err)
+	}
+
+	tampered := []byte(encrypted)
+	tampered[len(tampered)-2] ^= 0xff
+	if _, err := DecryptReply(string(tampered), encryptKey); err == nil {
+		t.Error("DecryptReply accepted tampered ciphertext")
+	}
+}
+
+func TestDecryptReplyRejectsWrongKey(t *testing.T) {
+	signKey := mustGenerateKey(t)
+	encryptKey := mustGenerateKey(t)
+	wrongKey := mustGenerateKey(t)
+	tasks := []tasking.Task{{Source: "source-a"}}
+
+	signed, err := SignTicket(tasks, signKey, "signing-kid", time.Hour)
+	if err != nil {
+		t.Fatalf("SignTicket: %v", err)
+	}
+	encrypted, err := EncryptTicketFor(signed, &encryptKey.PublicKey, "encrypt-kid")
+	if err != nil {
+		t.Fatalf("EncryptTicketFor: %v", err)
+	}
+
+	if _, err := DecryptReply(encrypted, wrongKey); err == nil {
+		t.Error("DecryptReply succeeded with the wrong private key")
+	}
+}
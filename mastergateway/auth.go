@@ -0,0 +1,260 @@
+package mastergateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"../utils"
+)
+
+const (
+	scopeSubmitTask   = "submit_task"
+	scopeUploadSample = "upload_sample"
+)
+
+// sessionClaims is the payload of a session token issued by /login.
+type sessionClaims struct {
+	UserID    int      `json:"user_id"`
+	Username  string   `json:"username"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	Scopes    []string `json:"scopes"`
+}
+
+var (
+	tokenSigningKey  []byte // HMAC key used to sign/verify session tokens
+	tokenAuthEnabled bool   // Whether Token.SigningKey was configured; /login refuses to issue tokens otherwise
+
+	dummyHashOnce sync.Once
+	dummyHash     []byte // Fixed-cost bcrypt hash compared against on unknown usernames, to avoid leaking existence via timing
+
+	loginAttemptsMutex sync.Mutex
+	loginAttempts      = make(map[string]*loginAttemptState)
+)
+
+type loginAttemptState struct {
+	failures    int
+	lockedUntil time.Time
+	lastAttempt time.Time
+}
+
+// loadTokenSigningKey decodes the HMAC key used for session tokens from the
+// config file. It is a dedicated key, separate from ticketSignKey, since
+// tokens are HMAC-signed rather than RSA-signed.
+//
+// Session tokens are optional: a deployment that only ever uses legacy
+// username/password on /task/ and /samples/ doesn't need one configured, so
+// a missing Token.SigningKey just disables /login rather than aborting
+// startup.
+func loadTokenSigningKey() {
+	if conf.Token.SigningKey == "" {
+		log.Println("Token.SigningKey not set, /login is disabled; falling back to legacy username/password auth only")
+		return
+	}
+	key, err := base64.StdEncoding.DecodeString(conf.Token.SigningKey)
+	if err != nil {
+		log.Fatal("Error decoding Token.SigningKey (expected base64): ", err)
+	}
+	if len(key) == 0 {
+		log.Fatal("Token.SigningKey decoded to an empty key")
+	}
+	if conf.Token.TTLSeconds <= 0 {
+		log.Fatal("Token.TTLSeconds must be set to a positive value when Token.SigningKey is configured")
+	}
+	tokenSigningKey = key
+	tokenAuthEnabled = true
+}
+
+// issueToken signs a short-lived session token for user, scoped to scopes.
+func issueToken(user *tasking.User, scopes []string) (string, error) {
+	now := time.Now()
+	ttl := time.Duration(conf.Token.TTLSeconds) * time.Second
+	claims := sessionClaims{
+		UserID:    user.Id,
+		Username:  user.Name,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Scopes:    scopes,
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encodedBody := base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(sha256.New, tokenSigningKey)
+	mac.Write([]byte(encodedBody))
+	encodedMac := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return encodedBody + "." + encodedMac, nil
+}
+
+// parseToken verifies a session token's signature and expiry and returns
+// its claims.
+func parseToken(token string) (*sessionClaims, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("Malformed token")
+	}
+	encodedBody, givenMac := parts[0], parts[1]
+
+	mac := hmac.New(sha256.New, tokenSigningKey)
+	mac.Write([]byte(encodedBody))
+	expectedMac := mac.Sum(nil)
+
+	decodedMac, err := base64.RawURLEncoding.DecodeString(givenMac)
+	if err != nil || subtle.ConstantTimeCompare(decodedMac, expectedMac) != 1 {
+		return nil, errors.New("Invalid token signature")
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, errors.New("Malformed token")
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, errors.New("Malformed token")
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, errors.New("Token expired")
+	}
+	return &claims, nil
+}
+
+// scopeAllowed reports whether required is amongst scopes.
+func scopeAllowed(scopes []string, required string) bool {
+	for _, s := range scopes {
+		if s == required {
+			return true
+		}
+	}
+	return false
+}
+
+// getDummyHash returns a fixed bcrypt hash, at the configured cost, that
+// authenticate compares against when the username doesn't exist, so a
+// missing user takes the same time as a wrong password.
+func getDummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("dummy-password-for-constant-time-compare"), conf.BcryptCost)
+		if err != nil {
+			log.Fatal("Error generating dummy bcrypt hash: ", err)
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
+
+// checkLoginLockout returns an error if username is currently locked out
+// after too many failed attempts.
+func checkLoginLockout(username string) error {
+	loginAttemptsMutex.Lock()
+	defer loginAttemptsMutex.Unlock()
+	state, exists := loginAttempts[username]
+	if exists && time.Now().Before(state.lockedUntil) {
+		return errors.New("Account temporarily locked, try again later")
+	}
+	return nil
+}
+
+func recordLoginFailure(username string) {
+	loginAttemptsMutex.Lock()
+	defer loginAttemptsMutex.Unlock()
+
+	now := time.Now()
+	sweepLoginAttemptsLocked(now)
+
+	state, exists := loginAttempts[username]
+	if !exists {
+		state = &loginAttemptState{}
+		loginAttempts[username] = state
+	}
+	state.failures++
+	state.lastAttempt = now
+	if state.failures >= conf.LoginRateLimit.MaxAttempts {
+		state.lockedUntil = now.Add(time.Duration(conf.LoginRateLimit.LockoutSeconds) * time.Second)
+		state.failures = 0
+	}
+}
+
+// sweepLoginAttemptsLocked evicts entries that are neither currently locked
+// out nor recently touched, so usernames that only ever fail once or twice
+// (e.g. an attacker spraying random usernames at /login) don't accumulate
+// in loginAttempts forever. Callers must hold loginAttemptsMutex.
+func sweepLoginAttemptsLocked(now time.Time) {
+	idle := time.Duration(conf.LoginRateLimit.LockoutSeconds) * time.Second
+	for username, state := range loginAttempts {
+		if state.lockedUntil.After(now) {
+			continue
+		}
+		if now.Sub(state.lastAttempt) > idle {
+			delete(loginAttempts, username)
+		}
+	}
+}
+
+func recordLoginSuccess(username string) {
+	loginAttemptsMutex.Lock()
+	defer loginAttemptsMutex.Unlock()
+	delete(loginAttempts, username)
+}
+
+// authenticateBearer authenticates r from its "Authorization: Bearer <token>"
+// header alone, without needing anything from the request body. ok is false
+// when the request carries no bearer token at all, meaning the caller must
+// fall back to legacy username/password credentials once those have been
+// read from the body; callers can use this to authenticate before reading
+// the body when a bearer token is present.
+func authenticateBearer(r *http.Request, requiredScope string) (user *tasking.User, err error, ok bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil, false
+	}
+	claims, err := parseToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return nil, errors.New("Authentication failed"), true
+	}
+	if !scopeAllowed(claims.Scopes, requiredScope) {
+		return nil, errors.New("Token does not grant the required scope"), true
+	}
+	return &tasking.User{Id: claims.UserID, Name: claims.Username}, nil, true
+}
+
+// resolveUser authenticates an incoming request, accepting either an
+// "Authorization: Bearer <token>" header or legacy username/password
+// credentials, and checks that the resulting scopes allow requiredScope.
+func resolveUser(r *http.Request, username string, password string, requiredScope string) (*tasking.User, error) {
+	if user, err, ok := authenticateBearer(r, requiredScope); ok {
+		return user, err
+	}
+	return authenticate(username, password)
+}
+
+func httpLogin(w http.ResponseWriter, r *http.Request) {
+	if !tokenAuthEnabled {
+		http.Error(w, "Session tokens are not enabled on this gateway", 501)
+		return
+	}
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	user, err := authenticate(username, password)
+	if err != nil {
+		http.Error(w, err.Error(), 401)
+		return
+	}
+	token, err := issueToken(user, []string{scopeSubmitTask, scopeUploadSample})
+	if err != nil {
+		log.Println("Error issuing token: ", err)
+		http.Error(w, "Error issuing token", 500)
+		return
+	}
+	w.Write([]byte(token))
+}
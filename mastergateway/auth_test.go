@@ -0,0 +1,189 @@
+package mastergateway
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"../backend"
+	"../utils"
+)
+
+// testConfig returns a minimal conf sufficient for authenticate/issueToken/
+// parseToken/login-lockout, with bcrypt at its minimum cost so the tests
+// stay fast.
+func testConfig() *config {
+	return &config{
+		BcryptCost: bcrypt.MinCost,
+		Token:      tokenConfig{TTLSeconds: 60},
+		LoginRateLimit: loginRateLimitConfig{
+			MaxAttempts:    3,
+			LockoutSeconds: 60,
+		},
+	}
+}
+
+func resetLoginAttempts() {
+	loginAttemptsMutex.Lock()
+	loginAttempts = make(map[string]*loginAttemptState)
+	loginAttemptsMutex.Unlock()
+}
+
+func TestScopeAllowed(t *testing.T) {
+	scopes := []string{scopeSubmitTask}
+	if !scopeAllowed(scopes, scopeSubmitTask) {
+		t.Error("scopeAllowed = false, want true for a granted scope")
+	}
+	if scopeAllowed(scopes, scopeUploadSample) {
+		t.Error("scopeAllowed = true, want false for a scope that wasn't granted")
+	}
+}
+
+func TestIssueAndParseTokenRoundTrip(t *testing.T) {
+	conf = testConfig()
+	tokenSigningKey = make([]byte, 32)
+	rand.Read(tokenSigningKey)
+
+	user := &tasking.User{Id: 7, Name: "alice"}
+	token, err := issueToken(user, []string{scopeUploadSample})
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+
+	claims, err := parseToken(token)
+	if err != nil {
+		t.Fatalf("parseToken: %v", err)
+	}
+	if claims.UserID != user.Id || claims.Username != user.Name {
+		t.Errorf("claims = %+v, want user %+v", claims, user)
+	}
+	if !scopeAllowed(claims.Scopes, scopeUploadSample) {
+		t.Error("issued token lost its scope")
+	}
+}
+
+func TestParseTokenRejectsTamperedSignature(t *testing.T) {
+	conf = testConfig()
+	tokenSigningKey = make([]byte, 32)
+	rand.Read(tokenSigningKey)
+
+	user := &tasking.User{Id: 1, Name: "bob"}
+	token, err := issueToken(user, []string{scopeSubmitTask})
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+
+	// Change the second-to-last character: all six of its bits belong to the
+	// MAC, unlike the last one, whose low bits base64 decoding ignores.
+	tampered := []byte(token)
+	if tampered[len(tampered)-2] == 'A' {
+		tampered[len(tampered)-2] = 'B'
+	} else {
+		tampered[len(tampered)-2] = 'A'
+	}
+	if _, err := parseToken(string(tampered)); err == nil {
+		t.Error("parseToken accepted a tampered signature")
+	}
+}
+
+func TestParseTokenRejectsExpired(t *testing.T) {
+	conf = testConfig()
+	conf.Token.TTLSeconds = -1 // already expired the instant it's issued
+	tokenSigningKey = make([]byte, 32)
+	rand.Read(tokenSigningKey)
+
+	token, err := issueToken(&tasking.User{Id: 1, Name: "bob"}, nil)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	if _, err := parseToken(token); err == nil {
+		t.Error("parseToken accepted an expired token")
+	}
+}
+
+func TestAuthenticateMissingUserAndWrongPasswordFailAlike(t *testing.T) {
+	conf = testConfig()
+	resetLoginAttempts()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-password"), conf.BcryptCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	be = backend.NewConfigBackend(backend.ConfigBackendOptions{
+		AllowedUsers: []tasking.User{{Id: 1, Name: "alice", PasswordHash: string(hash)}},
+	})
+
+	// Both a nonexistent user and an existing user with the wrong password
+	// must run the same bcrypt comparison and return the same generic
+	// error, so neither path leaks which part was wrong, and neither
+	// responds faster than the other (the dummy-hash fallback is what makes
+	// that true; this only checks the outward behavior is identical).
+	_, missingErr := authenticate("no-such-user", "whatever")
+	if missingErr == nil {
+		t.Error("authenticate succeeded for a nonexistent user")
+	}
+	resetLoginAttempts()
+	_, wrongErr := authenticate("alice", "wrong-password")
+	if wrongErr == nil {
+		t.Error("authenticate succeeded with the wrong password")
+	}
+	if missingErr != nil && wrongErr != nil && missingErr.Error() != wrongErr.Error() {
+		t.Errorf("missing user error %q differs from wrong password error %q", missingErr, wrongErr)
+	}
+	resetLoginAttempts()
+
+	user, err := authenticate("alice", "correct-password")
+	if err != nil {
+		t.Fatalf("authenticate with the correct password failed: %v", err)
+	}
+	if user.Name != "alice" {
+		t.Errorf("authenticate returned user %+v, want alice", user)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	conf = testConfig()
+	conf.LoginRateLimit.MaxAttempts = 2
+	conf.LoginRateLimit.LockoutSeconds = 60
+	resetLoginAttempts()
+
+	recordLoginFailure("carol")
+	if err := checkLoginLockout("carol"); err != nil {
+		t.Fatalf("checkLoginLockout locked out after one failure: %v", err)
+	}
+	recordLoginFailure("carol")
+	if err := checkLoginLockout("carol"); err == nil {
+		t.Error("checkLoginLockout did not lock out after reaching MaxAttempts")
+	}
+
+	recordLoginSuccess("carol")
+	if err := checkLoginLockout("carol"); err != nil {
+		t.Errorf("checkLoginLockout still locked out after a recorded success: %v", err)
+	}
+}
+
+func TestRecordLoginFailureEvictsStaleEntries(t *testing.T) {
+	conf = testConfig()
+	conf.LoginRateLimit.LockoutSeconds = 60
+	resetLoginAttempts()
+
+	loginAttemptsMutex.Lock()
+	loginAttempts["stale-user"] = &loginAttemptState{
+		failures:    1,
+		lastAttempt: time.Now().Add(-2 * time.Hour),
+	}
+	loginAttemptsMutex.Unlock()
+
+	// Triggers a sweep as a side effect; "stale-user" is neither locked nor
+	// recently touched, so it should be evicted rather than accumulate
+	// forever.
+	recordLoginFailure("fresh-user")
+
+	loginAttemptsMutex.Lock()
+	_, stillPresent := loginAttempts["stale-user"]
+	loginAttemptsMutex.Unlock()
+	if stillPresent {
+		t.Error("sweepLoginAttemptsLocked left a stale, unlocked entry in place")
+	}
+}
@@ -6,25 +6,50 @@ import (
 	"log"
 	"sync"
 	"time"
-	"bytes"
 	"errors"
-	"strconv"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
-	"net/url"
-	"net/http/httputil"
 	"crypto/rsa"
 	"crypto/rand"
+	"crypto/tls"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"encoding/json"
 	"encoding/base64"
 	"../utils"
+	"../jose"
+	"../backend"
+	"../samplestore"
 )
 
+type httpClientConfig struct {
+	TimeoutSeconds         int  // Overall request timeout for the shared client, 0 means no timeout
+	IdleConnTimeoutSeconds int  // How long idle connections are kept in the pool
+	MaxIdleConns           int  // Maximum number of idle connections kept across all hosts
+	MaxIdleConnsPerHost    int  // Maximum number of idle connections kept per destination host
+	InsecureSkipVerify     bool // Skip TLS certificate verification (only for testing against self-signed peers)
+}
+
+type autoTLSConfig struct {
+	Enabled        bool     // Whether ACME-based certificate management is used instead of the static cert
+	Domains        []string // Domains the gateway's public endpoint is reachable under
+	CacheDir       string   // Directory autocert uses to persist issued certificates
+	ContactEmail   string   // Contact email passed to the ACME directory
+	DirectoryURL   string   // ACME directory URL (empty defaults to Let's Encrypt production)
+	AcceptTOS      bool     // Must be true for autocert to proceed, acknowledges the ACME CA's ToS
+	HTTPChallengeAddr string // Address the HTTP-01 challenge / HTTPS redirect listener binds to
+}
+
 type config struct {
 	HTTP                string // The HTTP-binding for listening (IP+Port)
 	SourcesKeysPath     string // Path to the public keys of the sources
 	TicketSignKeyPath   string // Path to the private key used for signing tickets
+	TicketEncryptKeyPath string // Path to the private key used for decrypting JOSE ticket replies (UseJoseTickets); must be a different keypair than TicketSignKeyPath
 	Organizations       []tasking.Organization // All the known organizations
 	OwnOrganization     string // The name of the own organization (Should also be present in the list "Organizations")
 	StorageURI          string // URI of HolmesStorage
@@ -32,21 +57,103 @@ type config struct {
 	CertificatePath     string
 	CertificateKeyPath  string
 	AllowedUsers        []tasking.User
+	AutoTLS             autoTLSConfig
+	HTTPClient          httpClientConfig
+	UseJoseTickets      bool // Use JOSE (JWS+JWE) ticket envelopes instead of the legacy RSA+AES one; a migration flag, to be removed once all organizations have upgraded
+	Backend             backendConfig
+	BcryptCost          int // Cost factor of the dummy bcrypt hash compared against for unknown users; stored password hashes keep their own cost, so this should match it
+	Token               tokenConfig
+	LoginRateLimit      loginRateLimitConfig
+	SampleStore         sampleStoreConfig
+}
+
+type backendConfig struct {
+	Type                   string // "config" (default, reads this file) or "storage" (reads HolmesStorage)
+	RefreshIntervalSeconds int    // How often the storage backend refreshes organizations/sources in the background
+}
+
+type tokenConfig struct {
+	SigningKey string // Base64-encoded HMAC key used to sign/verify session tokens issued by /login
+	TTLSeconds int    // Validity period of issued session tokens
+}
+
+type loginRateLimitConfig struct {
+	MaxAttempts    int // Consecutive failed logins before a user is locked out
+	LockoutSeconds int // How long a user stays locked out once MaxAttempts is reached
+}
+
+type sampleStoreConfig struct {
+	Driver         string      // "holmesstorage" (default), "s3" or "local"
+	S3             s3Config    // Used when Driver is "s3"
+	Local          localConfig // Used when Driver is "local"
+	MaxUploadBytes int64       // Upper bound on an incoming /samples/ request body, 0 means no limit
+}
+
+type s3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	UseSSL    bool
+}
+
+type localConfig struct {
+	BaseDir string // Directory samples are written under when Driver is "local"
 }
 
 var (
-	conf *config                               // The configuration struct
-	keys map[string]*rsa.PublicKey             // The public keys of the sources
-	keysMutex = &sync.Mutex{}                  // Mutex for the map, since keys could change during runtime
-	ticketSignKey *rsa.PrivateKey              // The private key used for signing tickets
-	ticketSignKeyName string                   // The id of the private key used for signing tickets
-	srcRouter map[string]*tasking.Organization // Which source should be routed to which organization
-	ownOrganization *tasking.Organization      // Pointer to the own organization in the list of organizations
-	storageURI url.URL                         // The URL to storage for redirecting object-storage requests
-	proxy *httputil.ReverseProxy               // The proxy object for redirecting object-storage requests
-	users map[string]*tasking.User             // Map: Username -> User-struct (TODO: Move to storage)
+	conf *config                          // The configuration struct
+	be backend.Backend                    // Users, public keys and source routing, from either the config file or HolmesStorage
+	ticketSignKey *rsa.PrivateKey         // The private key used for signing tickets
+	ticketSignKeyName string              // The id of the private key used for signing tickets
+	ticketEncryptKey *rsa.PrivateKey      // The private key used for decrypting JOSE ticket replies, distinct from ticketSignKey
+	ticketEncryptKeyName string           // The id of the private key used for decrypting JOSE ticket replies
+	ownOrgMutex = &sync.RWMutex{}         // Mutex for ownOrganization, refreshed whenever the backend's organizations change
+	ownOrganization *tasking.Organization // Pointer to the own organization in the list of organizations
+	taskClient *http.Client               // Shared, HTTP/2-enabled client used to talk to destination organizations
+	sampleDriver samplestore.Driver       // Where uploaded samples are stored
 )
 
+// initSampleStore builds the samplestore.Driver selected by
+// conf.SampleStore.Driver.
+func initSampleStore() {
+	switch conf.SampleStore.Driver {
+	case "s3":
+		client, err := minio.New(conf.SampleStore.S3.Endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(conf.SampleStore.S3.AccessKey, conf.SampleStore.S3.SecretKey, ""),
+			Secure: conf.SampleStore.S3.UseSSL,
+		})
+		if err != nil {
+			log.Fatal("Error creating S3 client: ", err)
+		}
+		sampleDriver = samplestore.NewS3Driver(client, conf.SampleStore.S3.Bucket)
+	case "local":
+		sampleDriver = samplestore.NewLocalDriver(conf.SampleStore.Local.BaseDir)
+	default:
+		sampleDriver = samplestore.NewHolmesStorageDriver(conf.StorageURI, taskClient)
+	}
+}
+
+// initHTTPClient builds the shared client used by sendTaskList/requestTaskList
+// to talk to the organizations' task endpoints, with an HTTP/2-enabled
+// transport so many concurrent task submissions can reuse a single
+// connection per organization.
+func initHTTPClient() {
+	transport := &http.Transport{
+		MaxIdleConns:        conf.HTTPClient.MaxIdleConns,
+		MaxIdleConnsPerHost: conf.HTTPClient.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(conf.HTTPClient.IdleConnTimeoutSeconds) * time.Second,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: conf.HTTPClient.InsecureSkipVerify},
+	}
+	if err := http2.ConfigureTransport(transport); err != nil {
+		log.Println("Error enabling HTTP/2 on the task client transport: ", err)
+	}
+	taskClient = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(conf.HTTPClient.TimeoutSeconds) * time.Second,
+	}
+}
+
 func createTicket(tasks []tasking.Task) (tasking.Ticket, error){
 	t := tasking.Ticket {
 		Expiration : time.Now().Add(3*time.Hour), //TODO: 3 Hours validity reasonable?
@@ -63,13 +170,10 @@ func createTicket(tasks []tasking.Task) (tasking.Ticket, error){
 
 func encryptKey(symKey []byte, asymKeyId string) ([]byte, error) {
 	// Fetch public key
-	keysMutex.Lock()
-	asymKey, exists := keys[asymKeyId]
-	keysMutex.Unlock()
 	log.Println("searching for key: " + asymKeyId)
-	log.Printf("%+v\n", keys)
-	if !exists {
-		return nil, errors.New("Public Key not found")
+	asymKey, err := be.GetPublicKey(asymKeyId)
+	if err != nil {
+		return nil, err
 	}
 	encrypted, err := tasking.RsaEncrypt(symKey, asymKey)
 	return encrypted, err
@@ -107,8 +211,7 @@ func requestTaskList(uri string, encryptedTicket *tasking.Encrypted, symKey []by
 	q.Add("Encrypted", base64.StdEncoding.EncodeToString(encryptedTicket.Encrypted))
 	req.URL.RawQuery = q.Encode()
 	log.Println(req.URL)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := taskClient.Do(req)
 	if err != nil {
 		return err, nil
 	}
@@ -121,42 +224,45 @@ func requestTaskList(uri string, encryptedTicket *tasking.Encrypted, symKey []by
 }
 
 func authenticate(username string, password string) (*tasking.User, error) {
-// TODO: Ask storage instead of configuration file for credentials
-	user, exists := users[username]
-	if !exists {
-		// TODO: Maybe compare some dummy value to prevent timing based attack
-		return nil, errors.New("Authentication failed")
+	if err := checkLoginLockout(username); err != nil {
+		return nil, err
 	}
-	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-	if err != nil {
+
+	user, err := be.GetUser(username)
+	hash := getDummyHash()
+	if err == nil {
+		hash = []byte(user.PasswordHash)
+	}
+	// Always run bcrypt, even for an unknown user, comparing against a
+	// fixed dummy hash of the same cost, so a missing user doesn't respond
+	// faster than a wrong password would.
+	cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err != nil || cmpErr != nil {
+		recordLoginFailure(username)
 		return nil, errors.New("Authentication failed")
-	} else {
-		log.Printf("Authenticated as %s\n", username)
 	}
+	recordLoginSuccess(username)
+	log.Printf("Authenticated as %s\n", username)
 	return user, nil
 }
 
-func handleTask(tasksStr string, username string, password string) (error, []tasking.TaskError) {
+func handleTask(tasksStr string, user *tasking.User) (error, []tasking.TaskError) {
 	tskerrors := make([]tasking.TaskError, 0)
 	// TODO: Maybe we want to store the UID in the task
-	_, err := authenticate(username, password)
-	if err != nil{
-		return err, nil
-	}
 	var tasks []tasking.Task
 	log.Println("Task: ", tasksStr)
-	err = json.Unmarshal([]byte(tasksStr), &tasks)
+	err := json.Unmarshal([]byte(tasksStr), &tasks)
 	if err != nil {
 		log.Println("Error while unmarshalling tasks: ", err)
 		return err, tskerrors
 	}
 
 	// Sort the tasks for their destination organizations, based on the
-	// source of the task and the srcRouter-configuration
+	// source of the task and the backend's source routing
 	tasklists := make(map[*tasking.Organization][]tasking.Task)
 	for _,task := range tasks {
-		org, found := srcRouter[task.Source]
-		if !found {
+		org, err := be.GetSourceRoute(task.Source)
+		if err != nil {
 			log.Printf("No route for source %s!\n", task.Source)
 			tskerrors = append(tskerrors, tasking.TaskError{
 				TaskStruct : task,
@@ -203,6 +309,10 @@ func handleTask(tasksStr string, username string, password string) (error, []tas
 }
 
 func sendTaskList(tasks []tasking.Task, org *tasking.Organization) (error, []byte){
+	if conf.UseJoseTickets {
+		return sendTaskListJose(tasks, org)
+	}
+
 	uri := org.Uri
 
 	// Retrieve the corresponding public key
@@ -251,26 +361,73 @@ func sendTaskList(tasks []tasking.Task, org *tasking.Organization) (error, []byt
 	return err, tskerrors
 }
 
-func readKeys() {
-	tasking.LoadKeysAndWatch(conf.SourcesKeysPath, ".pub",
-		func(name string){
-			keysMutex.Lock()
-			delete(keys, name)
-			keysMutex.Unlock()
-			log.Println(keys)
-		},
-		func(name string){
-			key, name, err := tasking.LoadPublicKey(name)
-			if err != nil {
-				log.Printf("Error reading key (%s):%s\n", name, err)
-				return
-			}
+// sendTaskListJose is the JOSE-based counterpart of sendTaskList: it signs
+// the tasks as a compact JWS and wraps that in a compact JWE addressed to
+// the destination organization's public key, instead of the bespoke
+// RSA+AES envelope.
+func sendTaskListJose(tasks []tasking.Task, org *tasking.Organization) (error, []byte) {
+	// See the note in sendTaskList: the source of the first task picks the
+	// destination organization's key.
+	asymKeyId := tasks[0].Source
 
-			keysMutex.Lock()
-			keys[name] = key
-			keysMutex.Unlock()
-			log.Println(keys)
-		})
+	destKey, err := be.GetPublicKey(asymKeyId)
+	if err != nil {
+		return err, nil
+	}
+
+	signed, err := jose.SignTicket(tasks, ticketSignKey, ticketSignKeyName, 3*time.Hour) //TODO: 3 Hours validity reasonable?
+	if err != nil {
+		log.Println("Error while signing ticket: ", err)
+		return err, nil
+	}
+
+	encrypted, err := jose.EncryptTicketFor(signed, destKey, asymKeyId)
+	if err != nil {
+		log.Println("Error while encrypting ticket: ", err)
+		return err, nil
+	}
+
+	err, tskerrors := requestTaskListJose(org.Uri, encrypted)
+	if err != nil {
+		log.Println("Error requesting task: ", err)
+		return err, tskerrors
+	}
+	return err, tskerrors
+}
+
+// requestTaskListJose issues the JOSE variant of the task request: the
+// encrypted ticket is sent as a single query parameter, negotiated via the
+// Accept header, and the reply is a compact JWE encrypted to the gateway's
+// own public key.
+func requestTaskListJose(uri string, encryptedTicket string) (error, []byte) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err, nil
+	}
+	req.Header.Set("Accept", "application/jose+json")
+	q := req.URL.Query()
+	q.Add("Ticket", encryptedTicket)
+	req.URL.RawQuery = q.Encode()
+	log.Println(req.URL)
+	resp, err := taskClient.Do(req)
+	if err != nil {
+		return err, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err, nil
+	}
+	log.Printf("Received: %+v\n", string(body))
+	plaintext, err := jose.DecryptReply(string(body), ticketEncryptKey)
+	if err != nil {
+		log.Println("Error while decrypting reply: ", err)
+		return err, nil
+	}
+	log.Printf("Decrypted: %+v\n", string(plaintext))
+	return nil, plaintext
+}
+
+func loadTicketSignKey() {
 	var err error
 	ticketSignKey, ticketSignKeyName, err = tasking.LoadPrivateKey(conf.TicketSignKeyPath)
 	if err != nil {
@@ -278,12 +435,31 @@ func readKeys() {
 	}
 }
 
+// loadTicketEncryptKey loads the gateway's own keypair used to decrypt JOSE
+// ticket replies. This is deliberately a separate keypair from
+// ticketSignKey: signing and decryption are different cryptographic
+// operations, and reusing one RSA key for both weakens the guarantees of
+// each. Only needed when UseJoseTickets is set.
+func loadTicketEncryptKey() {
+	var err error
+	ticketEncryptKey, ticketEncryptKeyName, err = tasking.LoadPrivateKey(conf.TicketEncryptKeyPath)
+	if err != nil {
+		log.Fatal("Error while reading key for decrypting ticket replies (%s):%s", ticketEncryptKeyName, err)
+	}
+}
+
 func httpRequestIncomingTask(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	task := r.FormValue("task")
 	username := r.FormValue("username")
 	password := r.FormValue("password")
-	err, tskerrors := handleTask(task, username, password)
+	user, err := resolveUser(r, username, password, scopeSubmitTask)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 401)
+		return
+	}
+	err, tskerrors := handleTask(task, user)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), 500)
@@ -301,84 +477,136 @@ func httpRequestIncomingTask(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type myTransport struct{
+// httpRequestIncomingSample parses the incoming multipart upload. Parts can
+// legally arrive in any order, and a client may well send the file before
+// the username/password/source/name fields, so the fields can't just be
+// read off package-level vars as each part streams by: the file part is
+// instead spooled to a temporary file on disk (still hashed/sized as it's
+// written, by the destination driver once invoked) while the remaining
+// parts are collected, and only once every part has been seen do we know
+// the full set of form values and can hand the spooled file to the
+// configured samplestore.Driver. That trades the zero-buffering goal for
+// order-independence: a Driver.Put call needs Meta up front, so there's no
+// way to stream the file straight into it without first knowing Source,
+// which may arrive after the file part.
+//
+// A bearer-token request is authenticated from its Authorization header
+// before any of this happens, since that doesn't need anything out of the
+// body. Legacy username/password credentials live inside the multipart body
+// itself, so that path can only be checked once the relevant fields have
+// been parsed; MaxUploadBytes still bounds how much such a request can make
+// us spool before we find out it's unauthenticated.
+func httpRequestIncomingSample(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.URL)
 
-}
+	bearerUser, bearerErr, hasBearer := authenticateBearer(r, scopeUploadSample)
+	if hasBearer && bearerErr != nil {
+		http.Error(w, bearerErr.Error(), 401)
+		return
+	}
 
-type storageResult struct {
-	Sha256 string
-	Sha1 string
-	Md5 string
-	Mime string
-	Source []string
-	Objname []string `json:obj_name`
-	Submissions []string
-}
+	if conf.SampleStore.MaxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, conf.SampleStore.MaxUploadBytes)
+	}
 
-type storageResponse struct {
-	ResponseCode int
-	Failure string
-	Result storageResult
-}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data request", 400)
+		return
+	}
 
-func(t *myTransport) RoundTrip(request *http.Request)(*http.Response, error) {
-	// Since accessing the Form-values of the request changes the reader,
-	// which cannot be rewinded / seeked, an error would be thrown, if the
-	// request was forwarded with the reader at the wrong position.
-	// For this reason, the whole body is read and two new readers are created:
-	// One to read the Form-values from, and one for restoring the original.
-	reqbuf, err := ioutil.ReadAll(request.Body)
+	tmp, err := ioutil.TempFile("", "sample-upload-*")
 	if err != nil {
-		log.Printf("Error reading body!", err)
-		return nil, err
+		log.Println("Error creating temp file for upload: ", err)
+		http.Error(w, "Error storing sample", 500)
+		return
 	}
-	reqrdr := ioutil.NopCloser(bytes.NewBuffer(reqbuf))
-	reqrdr2 := ioutil.NopCloser(bytes.NewBuffer(reqbuf))
-	request.Body = reqrdr
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	// Read the name and the source from the request, because they can not be
-	// reconstructed from storage's response.
-	name := request.FormValue("name")
-	source := request.FormValue("source")
+	var username, password, name, source, fileName string
+	gotFile := false
 
-	username := request.FormValue("username")
-	password := request.FormValue("password")
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Error reading multipart body", 400)
+			return
+		}
 
-	// restore the reader for the body
-	request.Body = reqrdr2
+		switch part.FormName() {
+		case "username":
+			username = readFormValue(part)
+		case "password":
+			password = readFormValue(part)
+		case "name":
+			name = readFormValue(part)
+		case "source":
+			source = readFormValue(part)
+		case "file":
+			fileName = part.FileName()
+			if _, err := io.Copy(tmp, part); err != nil {
+				part.Close()
+				log.Println("Error buffering uploaded file: ", err)
+				http.Error(w, "Error storing sample", 500)
+				return
+			}
+			gotFile = true
+		}
+		part.Close()
+	}
 
-	user, err := authenticate(username, password)
-	if err != nil {
-		return nil, err
+	if !gotFile {
+		http.Error(w, "Request did not contain a file part", 400)
+		return
+	}
+	if source == "" {
+		http.Error(w, "Request did not contain a source", 400)
+		return
+	}
+	if name == "" {
+		name = fileName
 	}
 
-	form, _ := url.ParseQuery(request.URL.RawQuery)
-	form.Set("user_id", strconv.Itoa(user.Id))
-	request.URL.RawQuery = form.Encode()
-	// Do the proxy-request
-	response, err := http.DefaultTransport.RoundTrip(request)
-	if err != nil {
-		log.Printf("Error performing proxy-request!", err)
-		return nil, err
+	user := bearerUser
+	if !hasBearer {
+		user, err = authenticate(username, password)
+		if err != nil {
+			http.Error(w, err.Error(), 401)
+			return
+		}
 	}
 
-	// Parse the response. If it was successful, execute automatic tasks
-	var resp storageResponse
-	buf, err := ioutil.ReadAll(response.Body)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		log.Println("Error seeking buffered upload: ", err)
+		http.Error(w, "Error storing sample", 500)
+		return
+	}
+	result, err := sampleDriver.Put(r.Context(), samplestore.Meta{
+		Filename: name,
+		Source:   source,
+		UserID:   user.Id,
+	}, tmp)
 	if err != nil {
-		log.Printf("Error reading body!", err)
-		return nil, err
+		log.Println("Error storing sample: ", err)
+		http.Error(w, "Error storing sample", 500)
+		return
 	}
-	rdr := ioutil.NopCloser(bytes.NewBuffer(buf))
-	
-	json.Unmarshal(buf, &resp)
-	log.Printf("%+v\n", resp)
-	if resp.ResponseCode == 1 {
-		log.Printf("Successfully uploaded sample with SHA256: %s",resp.Result.Sha256)
-		// Execute automatic tasks
-		if len(conf.AutoTasks) != 0 {
+
+	log.Printf("Successfully uploaded sample with SHA256: %s\n", result.Sha256)
+	if len(conf.AutoTasks) != 0 {
+		if conf.SampleStore.Driver != "" && conf.SampleStore.Driver != "holmesstorage" {
+			// PrimaryURI below assumes the sample can be fetched back from
+			// HolmesStorage by SHA-256, which only holds for the
+			// holmesstorage driver; the s3 and local drivers store it
+			// somewhere a worker fetching PrimaryURI can't reach.
+			log.Printf("Skipping automatic tasking for %s: auto-tasking is only supported with the holmesstorage sample store driver\n", result.Sha256)
+		} else {
 			task := tasking.Task{
-				PrimaryURI : conf.StorageURI + resp.Result.Sha256,
+				PrimaryURI : conf.StorageURI + result.Sha256,
 				SecondaryURI : "",
 				Filename : name,
 				Tasks : conf.AutoTasks,
@@ -389,59 +617,129 @@ func(t *myTransport) RoundTrip(request *http.Request)(*http.Response, error) {
 			}
 
 			log.Printf("Automatically executing %+v\n", task)
-			sendTaskList([]tasking.Task{task}, ownOrganization)
+			sendTaskList([]tasking.Task{task}, getOwnOrganization())
 		}
 	}
 
-	// restore the reader for the body
-	response.Body = rdr
-	return response, err
+	resp, _ := json.Marshal(result)
+	w.Write(resp)
 }
 
-func httpRequestIncomingSample(w http.ResponseWriter, r *http.Request) {
-	log.Println(r.URL)
-	*r.URL = storageURI
-
-	proxy.ServeHTTP(w, r)
+// readFormValue drains a non-file multipart part into a string. Used for
+// the small form fields alongside the streamed file part.
+func readFormValue(part *multipart.Part) string {
+	buf, _ := ioutil.ReadAll(part)
+	return string(buf)
 }
 
 func initHTTP() {
+	initSampleStore()
+
+	http.HandleFunc("/login", httpLogin)
 	http.HandleFunc("/task/", httpRequestIncomingTask)
-	storageURI, _ := url.Parse(conf.StorageURI)
-	proxy = httputil.NewSingleHostReverseProxy(storageURI)
-	proxy.Transport = &myTransport{}
 	http.HandleFunc("/samples/", httpRequestIncomingSample)
 	log.Printf("Listening on %s\n", conf.HTTP)
-	log.Fatal(http.ListenAndServeTLS(conf.HTTP, conf.CertificatePath, conf.CertificateKeyPath, nil))
-}
 
-func initSourceRouting() {
-	//TODO: make this dynamically configurable
-	ownOrganization = nil
-	srcRouter = make(map[string]*tasking.Organization)
-	log.Println("=====")
-	for num, org := range(conf.Organizations) {
-		log.Println(org)
-		for _, src := range(org.Sources) {
-			srcRouter[src] = &conf.Organizations[num]
+	server := &http.Server{Addr: conf.HTTP}
+	if err := http2.ConfigureServer(server, nil); err != nil {
+		log.Println("Error enabling HTTP/2 on the incoming listener: ", err)
+	}
+
+	if conf.AutoTLS.Enabled {
+		if !conf.AutoTLS.AcceptTOS {
+			log.Fatal("AutoTLS is enabled, but AcceptTOS was not set")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(conf.AutoTLS.Domains...),
+			Cache:      autocert.DirCache(conf.AutoTLS.CacheDir),
+			Email:      conf.AutoTLS.ContactEmail,
 		}
-		if org.Name == conf.OwnOrganization {
-			ownOrganization = &conf.Organizations[num]
+		if conf.AutoTLS.DirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: conf.AutoTLS.DirectoryURL}
 		}
+		// Set GetCertificate on the config http2.ConfigureServer already set
+		// up above, instead of replacing it outright, so the "h2" entry it
+		// added to NextProtos survives and ALPN still negotiates HTTP/2. Also
+		// add acme-tls/1 so autocert can complete a TLS-ALPN-01 challenge on
+		// this same listener: without it, the only way to ever obtain a
+		// certificate is the separate HTTP-01 listener below, which is
+		// itself optional (HTTPChallengeAddr), so AutoTLS could otherwise be
+		// enabled with no challenge type able to complete at all.
+		server.TLSConfig.GetCertificate = manager.GetCertificate
+		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, acme.ALPNProto)
+
+		if conf.AutoTLS.HTTPChallengeAddr != "" {
+			go func() {
+				log.Printf("Listening for ACME HTTP-01 challenges on %s\n", conf.AutoTLS.HTTPChallengeAddr)
+				log.Fatal(http.ListenAndServe(conf.AutoTLS.HTTPChallengeAddr, manager.HTTPHandler(nil)))
+			}()
+		}
+
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	} else {
+		log.Fatal(server.ListenAndServeTLS(conf.CertificatePath, conf.CertificateKeyPath))
 	}
-	log.Println("=====")
-	log.Println(srcRouter)
-	if ownOrganization == nil {
-		log.Fatal("Own organization was not found")
+}
+
+func getOwnOrganization() *tasking.Organization {
+	ownOrgMutex.RLock()
+	defer ownOrgMutex.RUnlock()
+	return ownOrganization
+}
+
+// refreshOwnOrganization re-derives the pointer to the gateway's own
+// organization from the backend's current list, so that renaming or
+// updating it in the backend propagates without a restart.
+func refreshOwnOrganization() {
+	orgs, err := be.ListOrganizations()
+	if err != nil {
+		log.Println("Error listing organizations: ", err)
+		return
+	}
+	for num := range orgs {
+		if orgs[num].Name == conf.OwnOrganization {
+			ownOrgMutex.Lock()
+			ownOrganization = &orgs[num]
+			ownOrgMutex.Unlock()
+			return
+		}
+	}
+	log.Println("Own organization was not found")
+}
+
+// watchBackendInvalidations re-derives any gateway-local state that's
+// cached from the backend whenever the backend reports its own cache for
+// that kind has changed.
+func watchBackendInvalidations() {
+	for kind := range be.Invalidations() {
+		if kind == "sources" {
+			refreshOwnOrganization()
+		}
 	}
 }
 
-func initUsers() {
-	users = make(map[string]*tasking.User)
-	for u := range(conf.AllowedUsers) {
-		user := &(conf.AllowedUsers[u])
-		users[user.Name] = user
+func initBackend() {
+	switch conf.Backend.Type {
+	case "storage":
+		be = backend.NewStorageBackend(backend.StorageBackendOptions{
+			BaseURI:         conf.StorageURI,
+			Client:          taskClient,
+			RefreshInterval: time.Duration(conf.Backend.RefreshIntervalSeconds) * time.Second,
+		})
+	default:
+		be = backend.NewConfigBackend(backend.ConfigBackendOptions{
+			AllowedUsers:    conf.AllowedUsers,
+			Organizations:   conf.Organizations,
+			SourcesKeysPath: conf.SourcesKeysPath,
+		})
 	}
+
+	refreshOwnOrganization()
+	if getOwnOrganization() == nil {
+		log.Fatal("Own organization was not found")
+	}
+	go watchBackendInvalidations()
 }
 
 func Start(confPath string) {
@@ -451,13 +749,13 @@ func Start(confPath string) {
 	err := json.NewDecoder(cfile).Decode(&conf)
 	tasking.FailOnError(err, "Couldn't read config file")
 
-	initSourceRouting()
-	initUsers()
-
-	// Parse the public keys
-	keys = make(map[string]*rsa.PublicKey)
-	readKeys()
-	//log.Println(keys)
+	loadTicketSignKey()
+	if conf.UseJoseTickets {
+		loadTicketEncryptKey()
+	}
+	loadTokenSigningKey()
+	initHTTPClient()
+	initBackend()
 
 	// Setup the HTTP-listener
 	initHTTP()
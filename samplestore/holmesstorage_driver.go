@@ -0,0 +1,133 @@
+package samplestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+)
+
+// holmesStorageResult mirrors the JSON shape HolmesStorage's /samples/
+// endpoint responds with.
+type holmesStorageResult struct {
+	Sha256      string
+	Sha1        string
+	Md5         string
+	Mime        string
+	Source      []string
+	ObjName     []string `json:"obj_name"`
+	Submissions []string
+}
+
+type holmesStorageResponse struct {
+	ResponseCode int
+	Failure      string
+	Result       holmesStorageResult
+}
+
+// HolmesStorageDriver stores samples in HolmesStorage itself, by streaming a
+// multipart upload to its /samples/ endpoint. This is the gateway's
+// original behavior, previously implemented as a raw reverse proxy.
+type HolmesStorageDriver struct {
+	BaseURI string
+	Client  *http.Client
+}
+
+// NewHolmesStorageDriver builds a Driver that forwards uploads to
+// HolmesStorage at baseURI using client.
+func NewHolmesStorageDriver(baseURI string, client *http.Client) *HolmesStorageDriver {
+	return &HolmesStorageDriver{BaseURI: baseURI, Client: client}
+}
+
+func (d *HolmesStorageDriver) Put(ctx context.Context, meta Meta, r io.Reader) (Result, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	mw := multipart.NewWriter(pipeWriter)
+
+	go func() {
+		var err error
+		defer func() {
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			pipeWriter.Close()
+		}()
+
+		if err = mw.WriteField("name", meta.Filename); err != nil {
+			return
+		}
+		if err = mw.WriteField("source", meta.Source); err != nil {
+			return
+		}
+		if err = mw.WriteField("user_id", strconv.Itoa(meta.UserID)); err != nil {
+			return
+		}
+		part, ferr := mw.CreateFormFile("file", meta.Filename)
+		if ferr != nil {
+			err = ferr
+			return
+		}
+		if _, err = io.Copy(part, r); err != nil {
+			return
+		}
+		err = mw.Close()
+	}()
+
+	req, err := http.NewRequest("POST", d.BaseURI, pipeReader)
+	if err != nil {
+		return Result{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	var parsed holmesStorageResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return Result{}, err
+	}
+	if parsed.ResponseCode != 1 {
+		return Result{}, fmt.Errorf("HolmesStorage rejected the upload: %s", parsed.Failure)
+	}
+
+	return Result{
+		Sha256: parsed.Result.Sha256,
+		Sha1:   parsed.Result.Sha1,
+		Md5:    parsed.Result.Md5,
+		Mime:   parsed.Result.Mime,
+	}, nil
+}
+
+func (d *HolmesStorageDriver) Stat(ctx context.Context, sha256Hex string) (Result, error) {
+	return Result{}, errors.New("Stat is not supported by HolmesStorageDriver")
+}
+
+func (d *HolmesStorageDriver) Get(ctx context.Context, sha256Hex string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", d.BaseURI+"/"+sha256Hex, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HolmesStorage returned status %d for %s", resp.StatusCode, sha256Hex)
+	}
+	return resp.Body, nil
+}
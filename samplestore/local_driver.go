@@ -0,0 +1,73 @@
+package samplestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LocalDriver stores samples as plain files under BaseDir, named by their
+// SHA-256 digest (computed via a HashingReader while writing, so the
+// filename isn't known until the upload has been fully streamed to disk).
+type LocalDriver struct {
+	BaseDir string
+}
+
+// NewLocalDriver builds a Driver that writes samples under baseDir.
+func NewLocalDriver(baseDir string) *LocalDriver {
+	return &LocalDriver{BaseDir: baseDir}
+}
+
+func (d *LocalDriver) pathFor(sha256Hex string) string {
+	// Two levels of fan-out so a single directory doesn't end up with
+	// millions of entries.
+	return filepath.Join(d.BaseDir, sha256Hex[0:2], sha256Hex[2:4], sha256Hex)
+}
+
+func (d *LocalDriver) Put(ctx context.Context, meta Meta, r io.Reader) (Result, error) {
+	tmp, err := ioutil.TempFile(d.BaseDir, "upload-*")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hashed := NewHashingReader(r)
+	if _, err := io.Copy(tmp, hashed); err != nil {
+		return Result{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return Result{}, err
+	}
+
+	sha256Hex, sha1Hex, md5Hex, size := hashed.Sums()
+	dest := d.pathFor(sha256Hex)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return Result{}, err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return Result{}, err
+	}
+
+	return Result{Sha256: sha256Hex, Sha1: sha1Hex, Md5: md5Hex, Size: size}, nil
+}
+
+func (d *LocalDriver) Stat(ctx context.Context, sha256Hex string) (Result, error) {
+	info, err := os.Stat(d.pathFor(sha256Hex))
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Sha256: sha256Hex, Size: info.Size()}, nil
+}
+
+func (d *LocalDriver) Get(ctx context.Context, sha256Hex string) (io.ReadCloser, error) {
+	f, err := os.Open(d.pathFor(sha256Hex))
+	if err != nil {
+		return nil, fmt.Errorf("opening sample %s: %s", sha256Hex, err.Error())
+	}
+	return f, nil
+}
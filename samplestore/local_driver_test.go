@@ -0,0 +1,50 @@
+package samplestore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLocalDriverPutGetStatRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	d := NewLocalDriver(dir)
+	content := []byte("sample file contents")
+
+	putResult, err := d.Put(context.Background(), Meta{Filename: "a.bin", Source: "source-a"}, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if putResult.Size != int64(len(content)) {
+		t.Errorf("Put result size = %d, want %d", putResult.Size, len(content))
+	}
+
+	statResult, err := d.Stat(context.Background(), putResult.Sha256)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if statResult.Size != putResult.Size {
+		t.Errorf("Stat size = %d, want %d", statResult.Size, putResult.Size)
+	}
+
+	rc, err := d.Get(context.Background(), putResult.Sha256)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading Get result: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Get returned %q, want %q", got, content)
+	}
+}
+
+func TestLocalDriverGetMissingSample(t *testing.T) {
+	d := NewLocalDriver(t.TempDir())
+	if _, err := d.Get(context.Background(), "0000000000000000000000000000000000000000000000000000000000000"); err == nil {
+		t.Error("Get succeeded for a sample that was never stored")
+	}
+}
@@ -0,0 +1,84 @@
+package samplestore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// S3Driver stores samples as objects in an S3-compatible bucket (AWS S3 or
+// MinIO), keyed by their SHA-256 digest.
+type S3Driver struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// NewS3Driver builds a Driver backed by an S3-compatible bucket, reached
+// through client.
+func NewS3Driver(client *minio.Client, bucket string) *S3Driver {
+	return &S3Driver{Client: client, Bucket: bucket}
+}
+
+func (d *S3Driver) Put(ctx context.Context, meta Meta, r io.Reader) (Result, error) {
+	hashed := NewHashingReader(r)
+
+	// The object key isn't known until the upload is hashed, so buffer to a
+	// temporary key first, then copy it into place and remove the
+	// temporary object. PutObject itself still streams straight from r. The
+	// temp key must be unique per upload, not derived from meta.Filename:
+	// two concurrent uploads sharing a name would otherwise collide on one
+	// staging object, and whichever finishes its CopyObject second would
+	// silently get the other upload's bytes under its own SHA-256 key.
+	tempSuffix, err := randomHex(16)
+	if err != nil {
+		return Result{}, err
+	}
+	tempKey := "uploads/.incoming-" + tempSuffix
+	_, err = d.Client.PutObject(ctx, d.Bucket, tempKey, hashed, -1, minio.PutObjectOptions{})
+	if err != nil {
+		return Result{}, err
+	}
+
+	sha256Hex, sha1Hex, md5Hex, size := hashed.Sums()
+	finalKey := objectKey(sha256Hex)
+
+	_, err = d.Client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: d.Bucket, Object: finalKey},
+		minio.CopySrcOptions{Bucket: d.Bucket, Object: tempKey})
+	if err != nil {
+		return Result{}, err
+	}
+	if err := d.Client.RemoveObject(ctx, d.Bucket, tempKey, minio.RemoveObjectOptions{}); err != nil {
+		return Result{}, err
+	}
+
+	return Result{Sha256: sha256Hex, Sha1: sha1Hex, Md5: md5Hex, Size: size}, nil
+}
+
+func (d *S3Driver) Stat(ctx context.Context, sha256Hex string) (Result, error) {
+	info, err := d.Client.StatObject(ctx, d.Bucket, objectKey(sha256Hex), minio.StatObjectOptions{})
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Sha256: sha256Hex, Size: info.Size}, nil
+}
+
+func (d *S3Driver) Get(ctx context.Context, sha256Hex string) (io.ReadCloser, error) {
+	return d.Client.GetObject(ctx, d.Bucket, objectKey(sha256Hex), minio.GetObjectOptions{})
+}
+
+func objectKey(sha256Hex string) string {
+	return "samples/" + sha256Hex
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,94 @@
+// Package samplestore abstracts where uploaded malware samples are stored.
+// The gateway used to just reverse-proxy /samples/ uploads to HolmesStorage;
+// the Driver interface lets that be swapped for an S3/MinIO bucket or the
+// local filesystem, and lets the gateway hash the upload while streaming it
+// to the driver instead of buffering the whole body in memory first.
+package samplestore
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Meta is what the gateway knows about an upload before it has been stored.
+type Meta struct {
+	Filename string
+	Source   string
+	UserID   int
+}
+
+// Result is what a Driver reports once a sample has been stored.
+type Result struct {
+	Sha256 string
+	Sha1   string
+	Md5    string
+	Size   int64
+	// Mime mirrors the field HolmesStorage's legacy /samples/ response
+	// carried, for clients that still read it. Only HolmesStorageDriver
+	// populates it; other drivers don't detect content type and leave it
+	// empty.
+	Mime string
+}
+
+// Driver is implemented by each supported object-storage backend.
+type Driver interface {
+	// Put stores the content read from r, described by meta, and reports
+	// where it ended up. Implementations should stream r rather than
+	// buffering it.
+	Put(ctx context.Context, meta Meta, r io.Reader) (Result, error)
+
+	// Stat reports the Result for an already-stored sample, identified by
+	// its SHA-256.
+	Stat(ctx context.Context, sha256Hex string) (Result, error)
+
+	// Get opens an already-stored sample for reading, identified by its
+	// SHA-256. The caller must close the returned reader.
+	Get(ctx context.Context, sha256Hex string) (io.ReadCloser, error)
+}
+
+// HashingReader wraps an io.Reader, computing SHA-256, SHA-1 and MD5 as the
+// data is read through it, so a Driver.Put call can hash an upload on the
+// fly instead of the caller needing a separate, buffered pass.
+type HashingReader struct {
+	r      io.Reader
+	sha256 hash.Hash
+	sha1   hash.Hash
+	md5    hash.Hash
+	size   int64
+}
+
+// NewHashingReader wraps r so that everything read through the result is
+// fed into SHA-256/SHA-1/MD5 as well.
+func NewHashingReader(r io.Reader) *HashingReader {
+	return &HashingReader{
+		r:      r,
+		sha256: sha256.New(),
+		sha1:   sha1.New(),
+		md5:    md5.New(),
+	}
+}
+
+func (h *HashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.sha256.Write(p[:n])
+		h.sha1.Write(p[:n])
+		h.md5.Write(p[:n])
+		h.size += int64(n)
+	}
+	return n, err
+}
+
+// Sums returns the hex-encoded digests and total size read so far. It's
+// only meaningful once the underlying reader has been fully drained.
+func (h *HashingReader) Sums() (sha256Hex string, sha1Hex string, md5Hex string, size int64) {
+	return hex.EncodeToString(h.sha256.Sum(nil)),
+		hex.EncodeToString(h.sha1.Sum(nil)),
+		hex.EncodeToString(h.md5.Sum(nil)),
+		h.size
+}
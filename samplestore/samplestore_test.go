@@ -0,0 +1,54 @@
+package samplestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestHashingReaderSums(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	hashed := NewHashingReader(bytes.NewReader(content))
+
+	got, err := ioutil.ReadAll(hashed)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("HashingReader altered the bytes read through it")
+	}
+
+	sha256Hex, sha1Hex, md5Hex, size := hashed.Sums()
+	const (
+		wantSha256 = "05c6e08f1d9fdafa03147fcb8f82f124c76d2f70e3d989dc8aadb5e7d7450bec"
+		wantSha1   = "16312751ef9307c3fd1afbcb993cdc80464ba0f1"
+		wantMd5    = "77add1d5f41223d5582fca736a5cb335"
+	)
+	if sha256Hex != wantSha256 {
+		t.Errorf("sha256 = %s, want %s", sha256Hex, wantSha256)
+	}
+	if sha1Hex != wantSha1 {
+		t.Errorf("sha1 = %s, want %s", sha1Hex, wantSha1)
+	}
+	if md5Hex != wantMd5 {
+		t.Errorf("md5 = %s, want %s", md5Hex, wantMd5)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+}
+
+func TestHashingReaderEmptyInput(t *testing.T) {
+	hashed := NewHashingReader(bytes.NewReader(nil))
+	if _, err := ioutil.ReadAll(hashed); err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	sha256Hex, _, _, size := hashed.Sums()
+	const wantEmptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if sha256Hex != wantEmptySha256 {
+		t.Errorf("sha256 of empty input = %s, want %s", sha256Hex, wantEmptySha256)
+	}
+	if size != 0 {
+		t.Errorf("size = %d, want 0", size)
+	}
+}